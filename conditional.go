@@ -0,0 +1,47 @@
+package workflow
+
+import (
+	"context"
+	"fmt"
+)
+
+// AddConditionalEdge wires action as a choice gateway: once previousState is
+// reached and action completes successfully, predicate inspects ctx and the
+// Value action produced, and returns the key of the single branch in
+// branches that should be entered. The chosen state is told it was reached;
+// every other branch is told it was skipped, so an AND-joined merge further
+// downstream doesn't wait forever on a branch that was never going to run.
+// As with AddEdge, action's declared input types (if any) are validated by
+// reflection against the producers feeding previousState.
+func (wf *WorkFlow) AddConditionalEdge(previousState State, action Action, predicate func(ctx context.Context, output Value) string, branches map[string]State) error {
+	standardAction, ok := action.(*StandardAction)
+	if !ok {
+		return fmt.Errorf("workflow: AddConditionalEdge requires a *StandardAction, got %T", action)
+	}
+	if err := validateInputs(previousState, action); err != nil {
+		return err
+	}
+	if _, ok := wf.states[previousState.StateName()]; !ok {
+		wf.states[previousState.StateName()] = previousState
+		wf.stateWg.Add(1)
+	}
+	wf.actions[action.ActionName()] = action
+	previousState.AddPostAction(action)
+	previousState.SetWorkFlow(wf)
+	action.AddPreviousState(previousState)
+	action.SetWorkFlow(wf)
+
+	standardAction.predicate = predicate
+	standardAction.branches = make(map[string]State, len(branches))
+	for key, postState := range branches {
+		if _, ok := wf.states[postState.StateName()]; !ok {
+			wf.states[postState.StateName()] = postState
+			wf.stateWg.Add(1)
+		}
+		postState.AddPreviousAction(action)
+		postState.SetWorkFlow(wf)
+		action.AddPostState(postState)
+		standardAction.branches[key] = postState
+	}
+	return nil
+}
@@ -0,0 +1,143 @@
+package workflow
+
+import (
+	"context"
+	"sync"
+)
+
+// Group coalesces concurrent calls for the same key into a single in-flight
+// execution, singleflight-style: if a call for key K is already running,
+// later callers attach as waiters and receive the same result instead of
+// running fn again. It is exposed on WorkFlow so processingFuncs that share
+// expensive sub-work across concurrent branches (e.g. a shared build target
+// or sub-workflow reached from several paths) can dedupe it by key.
+type Group struct {
+	mu    sync.Mutex
+	calls map[string]*call
+}
+
+// NewGroup returns an empty Group ready for use.
+func NewGroup() *Group {
+	return &Group{calls: make(map[string]*call)}
+}
+
+type call struct {
+	once    sync.Once
+	done    chan struct{}
+	val     interface{}
+	err     error
+	next    *call
+	fn      func(ctx context.Context) (interface{}, error)
+	waiters []context.Context
+}
+
+func (c *call) complete(val interface{}, err error, next *call) {
+	c.once.Do(func() {
+		c.val, c.err, c.next = val, err, next
+		close(c.done)
+	})
+}
+
+// Do runs fn and returns its result, making sure only one execution of key is
+// in flight at a time; concurrent Do calls for the same key share that one
+// execution's result. If the caller that started the in-flight execution
+// (the "leader") has its ctx canceled before fn returns, Do promotes one of
+// the remaining waiters whose own ctx is still live to a new leader that
+// re-runs fn, so a single caller's cancellation can't take down every other
+// caller sharing the key. The leader's own Do call still returns its own
+// ctx.Err() in that case; fn's original invocation keeps running in the
+// background since Go cannot forcibly stop a goroutine, and its eventual
+// result, if any, is discarded.
+func (g *Group) Do(ctx context.Context, key string, fn func(ctx context.Context) (interface{}, error)) (interface{}, error) {
+	g.mu.Lock()
+	c, inFlight := g.calls[key]
+	isLeader := !inFlight
+	if isLeader {
+		c = &call{done: make(chan struct{}), fn: fn}
+		g.calls[key] = c
+	} else {
+		c.waiters = append(c.waiters, ctx)
+	}
+	g.mu.Unlock()
+
+	if isLeader {
+		go func() {
+			val, err := c.fn(ctx)
+			g.finish(key, c, ctx, val, err)
+		}()
+	}
+
+	for {
+		select {
+		case <- c.done:
+			if c.next != nil {
+				c = c.next
+				continue
+			}
+			return c.val, c.err
+		case <- ctx.Done():
+			if isLeader {
+				g.promote(key, c)
+			}
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// finish records fn's result for c. It only attempts to promote a waiter
+// when fn failed AND the leader's own ctx was canceled: a fast-returning fn
+// that simply honored its leader's cancellation shouldn't hand that
+// cancellation error to every waiter sharing the key. A genuine,
+// deterministic error from fn — ctx not canceled — is never treated as
+// promotable; it's finalized for every current waiter as-is, per Do's
+// contract. This races harmlessly with Do's own ctx.Done() handler calling
+// promote directly — whichever gets there first wins, the other's promote
+// call finds the call already superseded and no-ops.
+func (g *Group) finish(key string, c *call, ctx context.Context, val interface{}, err error) {
+	if err != nil && ctx.Err() != nil && g.promote(key, c) {
+		return
+	}
+
+	c.complete(val, err, nil)
+
+	g.mu.Lock()
+	if g.calls[key] == c {
+		delete(g.calls, key)
+	}
+	g.mu.Unlock()
+}
+
+// promote hands the in-flight call for key to the first waiter whose
+// context hasn't also been canceled, re-running fn on its behalf. It
+// reports whether a waiter was promoted; false means c was left for the
+// caller to finalize normally.
+func (g *Group) promote(key string, c *call) bool {
+	g.mu.Lock()
+	if g.calls[key] != c {
+		g.mu.Unlock()
+		return false
+	}
+	var nextCtx context.Context
+	remaining := c.waiters[:0]
+	for _, wctx := range c.waiters {
+		if nextCtx == nil && wctx.Err() == nil {
+			nextCtx = wctx
+			continue
+		}
+		remaining = append(remaining, wctx)
+	}
+	if nextCtx == nil {
+		g.mu.Unlock()
+		return false
+	}
+	next := &call{done: make(chan struct{}), fn: c.fn, waiters: remaining}
+	g.calls[key] = next
+	g.mu.Unlock()
+
+	go func() {
+		val, err := next.fn(nextCtx)
+		g.finish(key, next, nextCtx, val, err)
+	}()
+	c.complete(nil, nil, next)
+	return true
+}
@@ -0,0 +1,73 @@
+package workflow
+
+import "sync"
+
+// defaultWorkers is the pool size used when NewWorkFlow is not given
+// WithWorkers, chosen to match the previous one-goroutine-per-action
+// behavior for small workflows while still bounding large ones.
+const defaultWorkers = 16
+
+// workerPool runs submitted funcs on a fixed set of goroutines. Submit
+// blocks once all workers are busy, giving the workflow back-pressure
+// instead of the unbounded goroutine-per-action fan-out it used to have.
+type workerPool struct {
+	tasks  chan func()
+	wg     sync.WaitGroup
+	mu     sync.RWMutex
+	closed bool
+}
+
+func newWorkerPool(workers int) *workerPool {
+	if workers <= 0 {
+		workers = defaultWorkers
+	}
+	p := &workerPool{tasks: make(chan func())}
+	p.wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer p.wg.Done()
+			for task := range p.tasks {
+				task()
+			}
+		}()
+	}
+	return p
+}
+
+// Submit hands fn to the next free worker, blocking if all workers are busy.
+// It's a silent no-op once Close has been called, rather than sending on
+// the closed tasks channel, since a state can still be mid-Submit when the
+// workflow decides to shut the pool down. Submit holds the pool's read lock
+// for the whole send so Close can't close tasks out from under a Submit
+// that's already past the closed check.
+func (p *workerPool) Submit(fn func()) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.closed {
+		return
+	}
+	p.tasks <- fn
+}
+
+// Close stops accepting new work and waits for in-flight tasks to drain. It
+// blocks until every Submit call already past its closed check has
+// finished sending, so it never races Submit into a send on a closed
+// channel.
+func (p *workerPool) Close() {
+	p.mu.Lock()
+	p.closed = true
+	close(p.tasks)
+	p.mu.Unlock()
+	p.wg.Wait()
+}
+
+// Option configures a WorkFlow at construction time.
+type Option func(*WorkFlow)
+
+// WithWorkers sets the number of workers in the pool that runs actions.
+// Without it, NewWorkFlow uses defaultWorkers.
+func WithWorkers(n int) Option {
+	return func(wf *WorkFlow) {
+		wf.workers = n
+	}
+}
@@ -0,0 +1,77 @@
+package workflow
+
+import (
+	"context"
+	"time"
+)
+
+// RetryPolicy describes how an action should be retried after a failed
+// attempt: up to MaxAttempts tries total, waiting InitialBackoff before the
+// second attempt and scaling the wait by Multiplier after each subsequent
+// failure, capped at MaxBackoff. Retryable, if set, decides which errors are
+// worth retrying; a nil Retryable retries every error.
+type RetryPolicy struct {
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	Multiplier     float64
+	MaxBackoff     time.Duration
+	Retryable      func(err error) bool
+}
+
+// ActionOption configures a StandardAction at construction time.
+type ActionOption func(*StandardAction)
+
+// WithRetry attaches a retry policy to an action, so Process re-invokes
+// processingFunc with exponential backoff after a retryable failure instead
+// of failing the workflow on the first error.
+func WithRetry(policy RetryPolicy) ActionOption {
+	return func(a *StandardAction) {
+		a.retry = &policy
+	}
+}
+
+// runWithRetry runs a.attempt up to the configured number of attempts,
+// sleeping between attempts according to the backoff schedule. The sleep
+// aborts immediately if ctx is canceled, so a workflow cancellation isn't
+// delayed by a pending backoff.
+func (a *StandardAction) runWithRetry(ctx context.Context) (Value, error) {
+	if a.retry == nil {
+		return a.attempt(ctx)
+	}
+
+	policy := a.retry
+	maxAttempts := policy.MaxAttempts
+	if maxAttempts < 1 {
+		maxAttempts = 1
+	}
+	backoff := policy.InitialBackoff
+
+	var val Value
+	var err error
+	for attemptNum := 1; attemptNum <= maxAttempts; attemptNum++ {
+		val, err = a.attempt(ctx)
+		if err == nil {
+			return val, nil
+		}
+		if attemptNum == maxAttempts {
+			break
+		}
+		if policy.Retryable != nil && !policy.Retryable(err) {
+			break
+		}
+
+		select {
+		case <- time.After(backoff):
+		case <- ctx.Done():
+			return Value{}, ctx.Err()
+		}
+
+		if policy.Multiplier > 0 {
+			backoff = time.Duration(float64(backoff) * policy.Multiplier)
+		}
+		if policy.MaxBackoff > 0 && backoff > policy.MaxBackoff {
+			backoff = policy.MaxBackoff
+		}
+	}
+	return Value{}, err
+}
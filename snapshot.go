@@ -0,0 +1,136 @@
+package workflow
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// StateSnapshot captures the persisted lifecycle status of a single state.
+type StateSnapshot struct {
+	Name   string `json:"name"`
+	Status Status `json:"status"`
+}
+
+// ActionSnapshot captures the persisted lifecycle status of a single action,
+// along with whatever output it produced.
+type ActionSnapshot struct {
+	Name   string      `json:"name"`
+	Status Status      `json:"status"`
+	Output interface{} `json:"output,omitempty"`
+}
+
+// EdgeSnapshot captures one previousState -> action -> postState edge so the
+// DAG topology can be rebuilt by Resume.
+type EdgeSnapshot struct {
+	From   string `json:"from"`
+	Action string `json:"action"`
+	To     string `json:"to"`
+}
+
+// WorkFlowSnapshot is a JSON-serializable checkpoint of a WorkFlow: its DAG
+// topology plus the status (and, for actions, output) of every node. It is
+// produced by WorkFlow.Snapshot and consumed by Resume.
+type WorkFlowSnapshot struct {
+	States  []StateSnapshot  `json:"states"`
+	Actions []ActionSnapshot `json:"actions"`
+	Edges   []EdgeSnapshot   `json:"edges"`
+}
+
+// Snapshot captures the current status of every state and action in wf,
+// plus the edges connecting them, so the workflow can later be rebuilt and
+// continued via Resume.
+func (wf *WorkFlow) Snapshot() WorkFlowSnapshot {
+	snap := WorkFlowSnapshot{}
+	for name, s := range wf.states {
+		snap.States = append(snap.States, StateSnapshot{Name: name, Status: s.Status()})
+	}
+	for name, a := range wf.actions {
+		var output interface{}
+		if sa, ok := a.(*StandardAction); ok {
+			output = sa.output.Data
+		}
+		snap.Actions = append(snap.Actions, ActionSnapshot{Name: name, Status: a.Status(), Output: output})
+		for _, prev := range a.PreviousStates() {
+			for _, post := range a.PostStates() {
+				snap.Edges = append(snap.Edges, EdgeSnapshot{From: prev.StateName(), Action: name, To: post.StateName()})
+			}
+		}
+	}
+	return snap
+}
+
+// SnapshotJSON is a convenience wrapper around Snapshot that marshals the
+// result, e.g. for writing a checkpoint to disk.
+func (wf *WorkFlow) SnapshotJSON() ([]byte, error) {
+	return json.Marshal(wf.Snapshot())
+}
+
+// Resume rebuilds a WorkFlow from a previously captured snapshot. states and
+// actions must contain the same named nodes the original workflow was built
+// from (processing funcs cannot be serialized, so the caller supplies them);
+// actions and states already marked done in the snapshot are skipped so that
+// Run only re-executes work that was still pending when the snapshot was
+// taken, under the given timeout (the snapshot does not record the original
+// workflow's timeout). opts are passed through to
+// NewWorkFlow, so a caller who built the original workflow with WithWorkers
+// should pass the same option here to keep the same concurrency bound
+// across the resume.
+func Resume(timeout time.Duration, data []byte, states map[string]State, actions map[string]Action, opts ...Option) (*WorkFlow, error) {
+	var snap WorkFlowSnapshot
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return nil, fmt.Errorf("workflow: resume: decode snapshot: %w", err)
+	}
+
+	wf := NewWorkFlow(timeout, opts...)
+	for _, e := range snap.Edges {
+		prev, ok := states[e.From]
+		if !ok {
+			return nil, fmt.Errorf("workflow: resume: unknown state %q", e.From)
+		}
+		post, ok := states[e.To]
+		if !ok {
+			return nil, fmt.Errorf("workflow: resume: unknown state %q", e.To)
+		}
+		action, ok := actions[e.Action]
+		if !ok {
+			return nil, fmt.Errorf("workflow: resume: unknown action %q", e.Action)
+		}
+		if err := wf.AddEdge(prev, action, post); err != nil {
+			return nil, fmt.Errorf("workflow: resume: %w", err)
+		}
+	}
+
+	doneStates := make(map[string]bool, len(snap.States))
+	for _, s := range snap.States {
+		if s.Status == StatusDone {
+			doneStates[s.Name] = true
+		}
+	}
+	doneOutputs := make(map[string]interface{}, len(snap.Actions))
+	for _, a := range snap.Actions {
+		if a.Status == StatusDone {
+			doneOutputs[a.Name] = a.Output
+		}
+	}
+
+	// Mark already-completed actions first so their post states' wait
+	// groups are satisfied, then mark the states themselves so Run skips
+	// straight past work that finished before the crash or restart.
+	for name, action := range wf.actions {
+		if output, ok := doneOutputs[name]; ok {
+			if sa, ok := action.(*StandardAction); ok {
+				sa.markDone(NewValue(output))
+			}
+		}
+	}
+	for name, state := range wf.states {
+		if doneStates[name] {
+			if ss, ok := state.(*StandardState); ok {
+				ss.markDone()
+			}
+		}
+	}
+
+	return wf, nil
+}
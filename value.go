@@ -0,0 +1,72 @@
+package workflow
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+)
+
+// Value is a typed payload produced by one action and consumed as an input
+// by the actions wired downstream of it.
+type Value struct {
+	Type reflect.Type
+	Data interface{}
+}
+
+// NewValue wraps data as a Value, recording its concrete type in Type.
+// AddEdge does not look at Type; it validates only the types declared with
+// WithOutputType and WithInputTypes.
+func NewValue(data interface{}) Value {
+	return Value{Type: reflect.TypeOf(data), Data: data}
+}
+
+// ProcessingFunc is the work function an action runs. It receives the
+// Values produced by the producers feeding its previous state, in that
+// state's producer order, and returns the Value it produces for its own
+// consumers.
+type ProcessingFunc func(ctx context.Context, inputs ...Value) (Value, error)
+
+// WithOutputType declares the reflect.Type an action's Value output will
+// carry, so AddEdge can validate it against downstream consumers.
+func WithOutputType(t reflect.Type) ActionOption {
+	return func(a *StandardAction) {
+		a.outputType = t
+	}
+}
+
+// WithInputTypes declares, in producer order, the reflect.Types an action
+// expects its inputs to carry. AddEdge validates this against the output
+// types declared by the producers feeding the action's previous state.
+func WithInputTypes(types ...reflect.Type) ActionOption {
+	return func(a *StandardAction) {
+		a.inputTypes = types
+	}
+}
+
+// validateInputs checks, via reflection, that action's declared input types
+// line up with the output types already declared by the producers feeding
+// previousState, so a mismatch is caught at wiring time instead of at run
+// time. Actions that don't declare input or output types are left
+// unvalidated.
+func validateInputs(previousState State, action Action) error {
+	sa, ok := action.(*StandardAction)
+	if !ok || sa.inputTypes == nil {
+		return nil
+	}
+	producers := *previousState.PreviousActions()
+	if len(producers) != len(sa.inputTypes) {
+		return fmt.Errorf("workflow: action %q declares %d input(s) but state %q has %d producer(s)",
+			action.ActionName(), len(sa.inputTypes), previousState.StateName(), len(producers))
+	}
+	for i, producer := range producers {
+		sp, ok := producer.(*StandardAction)
+		if !ok || sp.outputType == nil {
+			continue
+		}
+		if sp.outputType != sa.inputTypes[i] {
+			return fmt.Errorf("workflow: action %q input %d expects %s but producer %q declares %s",
+				action.ActionName(), i, sa.inputTypes[i], producer.ActionName(), sp.outputType)
+		}
+	}
+	return nil
+}
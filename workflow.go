@@ -3,6 +3,7 @@ package workflow
 import (
 	"context"
 	"fmt"
+	"reflect"
 	"sync"
 	"time"
 )
@@ -16,11 +17,47 @@ type WorkFlow struct {
 	timeout time.Duration
 	startState State
 	endStates []State
+	listener Listener
+	workers int
+	pool *workerPool
+	errMu sync.Mutex
+	err error
+	Group *Group
 }
 
-func NewWorkFlow(timeout time.Duration) *WorkFlow {
+// fail records err as the workflow's first failure (subsequent calls are
+// ignored) and cancels the workflow's context so every in-flight action and
+// state observes the cancellation.
+func (wf *WorkFlow) fail(err error) {
+	wf.errMu.Lock()
+	if wf.err == nil {
+		wf.err = err
+	}
+	wf.errMu.Unlock()
+	wf.cancelFunc()
+}
+
+// Listener lets a host observe action lifecycle events as a workflow runs,
+// e.g. to log progress or persist a Snapshot after every completed action.
+type Listener interface {
+	TaskStarted(actionName string)
+	TaskFinished(actionName string)
+	TaskFailed(actionName string)
+}
+
+// SetListener registers l to receive action lifecycle callbacks. Passing nil
+// disables notifications.
+func (wf *WorkFlow) SetListener(l Listener) {
+	wf.listener = l
+}
+
+func NewWorkFlow(timeout time.Duration, opts ...Option) *WorkFlow {
 	wf := WorkFlow{}
 	wf.Init(timeout)
+	for _, opt := range opts {
+		opt(&wf)
+	}
+	wf.pool = newWorkerPool(wf.workers)
 	return &wf
 }
 
@@ -30,6 +67,7 @@ func (wf *WorkFlow) Init(timeout time.Duration) {
 	wf.timeout = timeout
 	wf.states = make(map[string]State)
 	wf.actions = make(map[string]Action)
+	wf.Group = NewGroup()
 }
 
 func (wf *WorkFlow) StartState() State {
@@ -54,7 +92,14 @@ func (wf *WorkFlow) EndState() []State {
 	return wf.endStates
 }
 
-func (wf *WorkFlow) AddEdge(previousState State, action Action, postState State) {
+// AddEdge wires previousState -> action -> postState. If action declares
+// input types (via WithInputTypes), they're validated by reflection against
+// the output types already declared by the producers feeding previousState,
+// so a type mismatch is caught here instead of at run time.
+func (wf *WorkFlow) AddEdge(previousState State, action Action, postState State) error {
+	if err := validateInputs(previousState, action); err != nil {
+		return err
+	}
 	if _, ok := wf.states[previousState.StateName()]; !ok {
 		wf.states[previousState.StateName()] = previousState
 		wf.stateWg.Add(1)
@@ -71,17 +116,23 @@ func (wf *WorkFlow) AddEdge(previousState State, action Action, postState State)
 	action.AddPreviousState(previousState)
 	action.AddPostState(postState)
 	action.SetWorkFlow(wf)
+	return nil
 }
 
-func (wf *WorkFlow) Run() bool {
+// Run executes the workflow to completion and returns a non-nil error if
+// any action failed or timed out, or if the workflow itself timed out. The
+// worker pool backing it is closed once Run reaches any of its terminal
+// states, so a WorkFlow's pool goroutines don't outlive the call.
+func (wf *WorkFlow) Run() error {
 	if len(wf.states) <= 0 {
-		return false
+		wf.pool.Close()
+		return fmt.Errorf("workflow: no states to run")
 	}
 	for name, state := range wf.states {
 		s := state
 		fmt.Println(name + " entering...")
 		go func() {
-			s.Enter()
+			s.Enter(wf.cancelContext)
 		}()
 	}
 	ch := make(chan struct{})
@@ -92,18 +143,39 @@ func (wf *WorkFlow) Run() bool {
 	select {
 	case <- wf.cancelContext.Done():
 		fmt.Println("Workflow canceled...")
-		return false
+		wf.pool.Close()
+		wf.errMu.Lock()
+		err := wf.err
+		wf.errMu.Unlock()
+		if err != nil {
+			return err
+		}
+		return wf.cancelContext.Err()
 	case <- ch:
 		fmt.Println("Workflow done...")
-		return true
+		wf.pool.Close()
+		return nil
 	case <- time.After(wf.timeout):
 		fmt.Println("Workflow timeout...")
-		return false
+		wf.cancelFunc()
+		wf.pool.Close()
+		return fmt.Errorf("workflow: timed out after %s", wf.timeout)
 	}
 }
 
+// Status is the lifecycle stage of a state or action within a WorkFlow, as
+// captured by Snapshot and restored by Resume.
+type Status string
+
+const (
+	StatusPending Status = "pending"
+	StatusRunning Status = "running"
+	StatusDone Status = "done"
+	StatusFailed Status = "failed"
+)
+
 type State interface {
-	Enter()
+	Enter(ctx context.Context)
 	Leave() <- chan struct{}
 	AddPreviousAction(action Action)
 	AddPostAction(action Action)
@@ -112,29 +184,119 @@ type State interface {
 	PreviousActions() *[]Action
 	PostActions() *[]Action
 	PreviousActionDone()
+	PreviousActionSkipped()
+	Status() Status
 }
 
+// JoinMode controls how a StandardState's incoming actions combine to
+// decide when the state is reached.
+type JoinMode int
+
+const (
+	// JoinAll is an AND-join: the state waits for every previous action.
+	JoinAll JoinMode = iota
+	// JoinAny is an OR-join: the state is reached as soon as any one
+	// previous action completes, e.g. the taken branch of a choice.
+	JoinAny
+)
+
+// StateOption configures a StandardState at construction time.
+type StateOption func(*StandardState)
+
+// WithJoin sets the state's join semantics. The default is JoinAll.
+func WithJoin(mode JoinMode) StateOption {
+	return func(s *StandardState) {
+		s.joinMode = mode
+	}
+}
 
 type StandardState struct {
 	wg sync.WaitGroup
+	statusMu sync.Mutex
+	status Status
 	previousDoneCh chan struct{}
 	previousActions []Action
 	postActions []Action
 	stateName string
 	workFlow *WorkFlow
+	joinMode JoinMode
+	orOnce sync.Once
+	orCh chan struct{}
+	activatedMu sync.Mutex
+	activated bool
+	skipped int
 }
 
-func NewState(stateName string) *StandardState {
+func NewState(stateName string, opts ...StateOption) *StandardState {
 	state := StandardState{}
 	state.stateName = stateName
+	state.status = StatusPending
+	state.orCh = make(chan struct{})
+	for _, opt := range opts {
+		opt(&state)
+	}
 	return &state
 }
 
+func (s *StandardState) Status() Status {
+	s.statusMu.Lock()
+	defer s.statusMu.Unlock()
+	return s.status
+}
+
+func (s *StandardState) setStatus(status Status) {
+	s.statusMu.Lock()
+	s.status = status
+	s.statusMu.Unlock()
+}
+
+// markDone marks the state as already completed, e.g. when rebuilt by
+// Resume, without re-running any of its previous actions.
+func (s *StandardState) markDone() {
+	s.setStatus(StatusDone)
+	s.activatedMu.Lock()
+	s.activated = true
+	s.activatedMu.Unlock()
+}
+
 func (s *StandardState) PreviousActions() *[]Action {
 	return &s.previousActions
 }
 
+// PreviousActionDone signals that a previous action genuinely reached this
+// state, e.g. it was the branch a choice gateway selected.
 func (s *StandardState) PreviousActionDone() {
+	s.activatedMu.Lock()
+	s.activated = true
+	s.activatedMu.Unlock()
+	if s.joinMode == JoinAny {
+		s.orOnce.Do(func() {
+			close(s.orCh)
+		})
+		return
+	}
+	s.wg.Done()
+}
+
+// PreviousActionSkipped signals that a previous action completed but chose
+// a different branch, so it never really reached this state. It still
+// counts against an AND-join's wait group so the merge doesn't hang
+// waiting on a branch that was never going to arrive. An OR-join ignores a
+// skip unless every one of its previous actions was skipped, in which case
+// it is released unactivated so the skip keeps cascading.
+func (s *StandardState) PreviousActionSkipped() {
+	if s.joinMode == JoinAny {
+		s.activatedMu.Lock()
+		s.skipped++
+		allSkipped := s.skipped == len(s.previousActions)
+		s.activatedMu.Unlock()
+		if allSkipped {
+			s.orOnce.Do(func() {
+				close(s.orCh)
+			})
+		}
+		return
+	}
 	s.wg.Done()
 }
 
@@ -150,19 +312,60 @@ func (s *StandardState) StateName() string {
 	return s.stateName
 }
 
-func (s *StandardState) Enter() {
+func (s *StandardState) Enter(ctx context.Context) {
 	fmt.Println(s.stateName + " is waiting...")
-	s.wg.Wait()
+	if s.joinMode == JoinAny && len(s.previousActions) > 0 {
+		select {
+		case <- s.orCh:
+		case <- ctx.Done():
+			return
+		}
+	} else {
+		s.wg.Wait()
+	}
+	select {
+	case <- ctx.Done():
+		return
+	default:
+	}
+	s.setStatus(StatusDone)
+	s.activatedMu.Lock()
+	activated := s.activated || len(s.previousActions) == 0
+	s.activatedMu.Unlock()
+	if !activated {
+		fmt.Println(s.stateName + " reached only via skipped branches, not entering...")
+		s.propagateSkip()
+		s.Leave()
+		return
+	}
 	fmt.Println(s.stateName + " reached, spawn all post actions...")
 	for _, action := range s.postActions {
 		a := action
-		go func() {
-			a.Process()
-		}()
+		s.workFlow.pool.Submit(func() {
+			if err := a.Process(ctx); err != nil {
+				fmt.Println(err)
+			}
+		})
 	}
 	s.Leave()
 }
 
+// propagateSkip tells every state reached through this state's post actions
+// that it was skipped, since those actions will never run to signal their
+// own post states themselves. Each of those states applies the same logic
+// when it is in turn found to be unactivated, so a skip cascades through
+// any number of hops instead of only reaching the conditional action's
+// immediate branch state — otherwise an AND-joined merge several states
+// downstream of a choice gateway would wait forever on a branch that was
+// never going to run.
+func (s *StandardState) propagateSkip() {
+	for _, action := range s.postActions {
+		for _, post := range action.PostStates() {
+			post.PreviousActionSkipped()
+		}
+	}
+}
+
 func (s *StandardState) Leave() <- chan struct{} {
 	fmt.Println(s.stateName + " leaving...")
 	ch := make(chan struct{})
@@ -174,7 +377,9 @@ func (s *StandardState) Leave() <- chan struct{} {
 }
 
 func (s *StandardState) AddPreviousAction(action Action) {
-	s.wg.Add(1)
+	if s.joinMode != JoinAny {
+		s.wg.Add(1)
+	}
 	s.previousActions = append(s.previousActions, action)
 }
 
@@ -183,11 +388,15 @@ func (s *StandardState) AddPostAction(action Action) {
 }
 
 type Action interface {
-	Process()
+	Process(ctx context.Context) error
 	AddPreviousState(state State)
 	AddPostState(state State)
 	ActionName() string
 	SetWorkFlow(workflow *WorkFlow)
+	PreviousStates() []State
+	PostStates() []State
+	Status() Status
+	Output() Value
 }
 
 
@@ -196,19 +405,29 @@ type StandardAction struct {
 	postStates []State
 	timeout time.Duration
 	actionName string
-	processingFunc func() bool
-	doneChan chan struct{}
-	failChan chan struct{}
+	processingFunc ProcessingFunc
 	workFlow *WorkFlow
+	statusMu sync.Mutex
+	status Status
+	output Value
+	inputTypes []reflect.Type
+	outputType reflect.Type
+	predicate func(ctx context.Context, output Value) string
+	branches map[string]State
+	retry *RetryPolicy
+	attemptMu sync.Mutex
+	inFlight chan struct{}
 }
 
-func NewAction(actionName string, timeout time.Duration, processingFunc func() bool) Action {
+func NewAction(actionName string, timeout time.Duration, processingFunc ProcessingFunc, opts ...ActionOption) Action {
 	action := StandardAction{}
 	action.timeout = timeout
 	action.actionName = actionName
 	action.processingFunc = processingFunc
-	action.doneChan = make(chan struct{})
-	action.failChan = make(chan struct{})
+	action.status = StatusPending
+	for _, opt := range opts {
+		opt(&action)
+	}
 	return &action
 }
 
@@ -220,29 +439,166 @@ func (a *StandardAction) ActionName() string {
 	return a.actionName
 }
 
-func (a *StandardAction) Process() {
+func (a *StandardAction) Status() Status {
+	a.statusMu.Lock()
+	defer a.statusMu.Unlock()
+	return a.status
+}
+
+func (a *StandardAction) setStatus(status Status) {
+	a.statusMu.Lock()
+	a.status = status
+	a.statusMu.Unlock()
+}
+
+// Output returns the Value the action produced. It is only meaningful once
+// Status is StatusDone; a still-pending or running action returns the zero
+// Value.
+func (a *StandardAction) Output() Value {
+	return a.output
+}
+
+// markDone marks the action as already completed with the given output,
+// e.g. when rebuilt by Resume, and notifies its post states without
+// re-invoking processingFunc.
+func (a *StandardAction) markDone(output Value) {
+	a.setStatus(StatusDone)
+	a.output = output
+	for _, s := range a.postStates {
+		s.PreviousActionDone()
+	}
+}
+
+// Process runs the action's processingFunc under a per-action timeout
+// derived from ctx. The derived context is passed to processingFunc so it
+// can observe cancellation (workflow cancel or its own timeout) and stop
+// early instead of running to completion unobserved.
+func (a *StandardAction) Process(ctx context.Context) error {
+	if a.Status() == StatusDone {
+		return nil
+	}
+	if a.workFlow != nil && a.workFlow.listener != nil {
+		a.workFlow.listener.TaskStarted(a.actionName)
+	}
+	a.setStatus(StatusRunning)
 	fmt.Println(a.actionName + " is processing...")
+
+	val, err := a.runWithRetry(ctx)
+
+	if err != nil {
+		a.setStatus(StatusFailed)
+		if a.workFlow != nil && a.workFlow.listener != nil {
+			a.workFlow.listener.TaskFailed(a.actionName)
+		}
+		wrapped := fmt.Errorf("action %q: %w", a.actionName, err)
+		if a.workFlow != nil {
+			a.workFlow.fail(wrapped)
+		}
+		return wrapped
+	}
+
+	a.output = val
+	a.setStatus(StatusDone)
+	if a.workFlow != nil && a.workFlow.listener != nil {
+		a.workFlow.listener.TaskFinished(a.actionName)
+	}
+
+	if a.predicate != nil {
+		return a.resolveBranch(ctx, val)
+	}
+	for _, s := range a.postStates {
+		s.PreviousActionDone()
+	}
+	return nil
+}
+
+// gatherInputs collects the Values produced by the producers feeding the
+// action's previous state, in that state's producer order, so they can be
+// passed to processingFunc as its inputs.
+func (a *StandardAction) gatherInputs() []Value {
+	if len(a.previousStates) == 0 {
+		return nil
+	}
+	producers := *a.previousStates[0].PreviousActions()
+	inputs := make([]Value, len(producers))
+	for i, producer := range producers {
+		inputs[i] = producer.Output()
+	}
+	return inputs
+}
+
+// attempt runs processingFunc once under a per-action timeout derived from
+// ctx, passing it the Values produced by the action's producers. The
+// derived context is passed to processingFunc so it can observe
+// cancellation (workflow cancel or its own timeout) and stop early instead
+// of running to completion unobserved. If a prior attempt's goroutine is
+// still running because processingFunc didn't honor that cancellation, this
+// attempt waits for it to actually return before starting a new one, so a
+// retry can never run concurrently with the attempt it's retrying.
+func (a *StandardAction) attempt(ctx context.Context) (Value, error) {
+	a.attemptMu.Lock()
+	prevInFlight := a.inFlight
+	a.attemptMu.Unlock()
+	if prevInFlight != nil {
+		select {
+		case <- prevInFlight:
+		case <- ctx.Done():
+			return Value{}, ctx.Err()
+		}
+	}
+
+	actionCtx, cancel := context.WithTimeout(ctx, a.timeout)
+	defer cancel()
+
+	inFlight := make(chan struct{})
+	a.attemptMu.Lock()
+	a.inFlight = inFlight
+	a.attemptMu.Unlock()
+
+	type result struct {
+		val Value
+		err error
+	}
+	resultCh := make(chan result, 1)
 	go func() {
-		if a.processingFunc != nil {
-			result := a.processingFunc()
-			if result {
-				close(a.doneChan)
-			} else {
-				close(a.failChan)
-			}
+		defer close(inFlight)
+		if a.processingFunc == nil {
+			resultCh <- result{}
+			return
 		}
-		time.Sleep(time.Second)
+		val, err := a.processingFunc(actionCtx, a.gatherInputs()...)
+		resultCh <- result{val, err}
 	}()
+
 	select {
-	case <- a.doneChan:
-		for _, s := range a.postStates {
+	case r := <- resultCh:
+		return r.val, r.err
+	case <- actionCtx.Done():
+		return Value{}, actionCtx.Err()
+	}
+}
+
+// resolveBranch picks the successor branch for a conditional action and
+// tells its chosen state it was reached, while every other branch is told
+// it was skipped so an AND-join downstream doesn't wait on it forever.
+func (a *StandardAction) resolveBranch(ctx context.Context, output Value) error {
+	key := a.predicate(ctx, output)
+	if _, ok := a.branches[key]; !ok {
+		err := fmt.Errorf("action %q: predicate chose unknown branch %q", a.actionName, key)
+		a.setStatus(StatusFailed)
+		if a.workFlow != nil {
+			a.workFlow.fail(err)
+		}
+		return err
+	}
+	for branchKey, s := range a.branches {
+		if branchKey == key {
 			s.PreviousActionDone()
+		} else {
+			s.PreviousActionSkipped()
 		}
-	case <- a.failChan:
-		a.workFlow.cancelFunc()
-	case <- time.After(a.timeout):
-		a.workFlow.cancelFunc()
 	}
+	return nil
 }
 
 func (a *StandardAction) AddPreviousState(state State) {
@@ -252,3 +608,11 @@ func (a *StandardAction) AddPreviousState(state State) {
 func (a *StandardAction) AddPostState(state State) {
 	a.postStates = append(a.postStates, state)
 }
+
+func (a *StandardAction) PreviousStates() []State {
+	return a.previousStates
+}
+
+func (a *StandardAction) PostStates() []State {
+	return a.postStates
+}
@@ -1,23 +1,29 @@
 package workflow
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
 
-func processTestFunc() bool {
+func processTestFunc(ctx context.Context, inputs ...Value) (Value, error) {
 	time.Sleep(time.Second * 2)
-	return true
+	return Value{}, nil
 }
 
-func processTestFailFunc() bool {
+func processTestFailFunc(ctx context.Context, inputs ...Value) (Value, error) {
 	time.Sleep(time.Second * 2)
-	return false
+	return Value{}, errors.New("action failed")
 }
 
-func processTestTimeoutFunc() bool {
+func processTestTimeoutFunc(ctx context.Context, inputs ...Value) (Value, error) {
 	time.Sleep(time.Second * 6)
-	return false
+	return Value{}, nil
 }
 
 func TestWorkFlow1 (t *testing.T) {
@@ -25,9 +31,11 @@ func TestWorkFlow1 (t *testing.T) {
 	s1 := NewState("start")
 	s2 := NewState("end")
 	a1 := NewAction("action1", time.Second * 3, processTestFunc)
-	wf.AddEdge(s1, a1, s2)
-	if wf.Run() != true {
-		t.Fail()
+	if err := wf.AddEdge(s1, a1, s2); err != nil {
+		t.Fatal(err)
+	}
+	if err := wf.Run(); err != nil {
+		t.Fatal(err)
 	}
 }
 
@@ -36,8 +44,10 @@ func TestWorkFlow2 (t *testing.T) {
 	s1 := NewState("start")
 	s2 := NewState("end")
 	a1 := NewAction("action1", time.Second * 3, processTestFailFunc)
-	wf.AddEdge(s1, a1, s2)
-	if wf.Run() != false {
+	if err := wf.AddEdge(s1, a1, s2); err != nil {
+		t.Fatal(err)
+	}
+	if err := wf.Run(); err == nil {
 		t.Fail()
 	}
 }
@@ -47,8 +57,489 @@ func TestWorkFlow3 (t *testing.T) {
 	s1 := NewState("start")
 	s2 := NewState("end")
 	a1 := NewAction("action1", time.Second * 3, processTestTimeoutFunc)
-	wf.AddEdge(s1, a1, s2)
-	if wf.Run() != false {
+	if err := wf.AddEdge(s1, a1, s2); err != nil {
+		t.Fatal(err)
+	}
+	if err := wf.Run(); err == nil {
 		t.Fail()
 	}
 }
+
+// TestWorkFlowPoolBoundsConcurrency fans a single state out into many
+// branches through a small worker pool and checks that no more of them run
+// at once than WithWorkers allows.
+func TestWorkFlowPoolBoundsConcurrency (t *testing.T) {
+	const workers = 2
+	const branches = 20
+
+	var concurrent, maxConcurrent int32
+	wf := NewWorkFlow(time.Second * 5, WithWorkers(workers))
+	start := NewState("start")
+
+	for i := 0; i < branches; i++ {
+		branchState := NewState(fmt.Sprintf("branch%d", i))
+		branchAction := NewAction(fmt.Sprintf("work%d", i), time.Second*3, func(ctx context.Context, inputs ...Value) (Value, error) {
+			n := atomic.AddInt32(&concurrent, 1)
+			for {
+				old := atomic.LoadInt32(&maxConcurrent)
+				if n <= old || atomic.CompareAndSwapInt32(&maxConcurrent, old, n) {
+					break
+				}
+			}
+			time.Sleep(time.Millisecond * 50)
+			atomic.AddInt32(&concurrent, -1)
+			return Value{}, nil
+		})
+		if err := wf.AddEdge(start, branchAction, branchState); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := wf.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if maxConcurrent > workers {
+		t.Fatalf("expected at most %d branches running at once, saw %d", workers, maxConcurrent)
+	}
+}
+
+// TestWorkFlowPoolCloseSurvivesConcurrentSubmitOnCancellation fans a single
+// state out into many branches through a small worker pool while one branch
+// fails fast, so the workflow cancels and Run closes the pool while the start
+// state is still calling Submit for the remaining branches' actions. pool.Close used
+// to race a concurrent Submit and crash the process with "send on closed
+// channel"; this only asserts Run returns cleanly instead of panicking.
+func TestWorkFlowPoolCloseSurvivesConcurrentSubmitOnCancellation (t *testing.T) {
+	const workers = 2
+	const branches = 50
+
+	wf := NewWorkFlow(time.Second * 5, WithWorkers(workers))
+	start := NewState("start")
+
+	fail := NewState("fail")
+	failAction := NewAction("failFast", time.Second*3, func(ctx context.Context, inputs ...Value) (Value, error) {
+		return Value{}, errors.New("fail fast")
+	})
+	if err := wf.AddEdge(start, failAction, fail); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < branches; i++ {
+		branchState := NewState(fmt.Sprintf("branch%d", i))
+		branchAction := NewAction(fmt.Sprintf("work%d", i), time.Second*3, func(ctx context.Context, inputs ...Value) (Value, error) {
+			time.Sleep(time.Millisecond * 50)
+			return Value{}, nil
+		})
+		if err := wf.AddEdge(start, branchAction, branchState); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := wf.Run(); err == nil {
+		t.Fatal("expected the workflow to fail because of failFast")
+	}
+}
+
+func TestWorkFlowRetrySucceedsOnSecondAttempt (t *testing.T) {
+	var attempts int
+	flaky := func(ctx context.Context, inputs ...Value) (Value, error) {
+		attempts++
+		if attempts < 2 {
+			return Value{}, errors.New("transient failure")
+		}
+		return Value{}, nil
+	}
+
+	wf := NewWorkFlow(time.Second * 5)
+	s1 := NewState("start")
+	s2 := NewState("end")
+	a1 := NewAction("flaky", time.Second * 3, flaky, WithRetry(RetryPolicy{
+		MaxAttempts:    3,
+		InitialBackoff: time.Millisecond * 10,
+		Multiplier:     2,
+		MaxBackoff:     time.Millisecond * 100,
+	}))
+	if err := wf.AddEdge(s1, a1, s2); err != nil {
+		t.Fatal(err)
+	}
+	if err := wf.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if attempts != 2 {
+		t.Fatalf("expected 2 attempts, got %d", attempts)
+	}
+}
+
+func TestWorkFlowRetryNeverRunsAttemptsConcurrently (t *testing.T) {
+	var concurrent, maxConcurrent int32
+	slow := func(ctx context.Context, inputs ...Value) (Value, error) {
+		n := atomic.AddInt32(&concurrent, 1)
+		for {
+			old := atomic.LoadInt32(&maxConcurrent)
+			if n <= old || atomic.CompareAndSwapInt32(&maxConcurrent, old, n) {
+				break
+			}
+		}
+		// Ignores ctx cancellation and outlives the per-attempt timeout
+		// below, the way a processingFunc with a slow underlying call
+		// might in practice.
+		time.Sleep(time.Millisecond * 300)
+		atomic.AddInt32(&concurrent, -1)
+		return Value{}, nil
+	}
+
+	wf := NewWorkFlow(time.Second * 5)
+	s1 := NewState("start")
+	s2 := NewState("end")
+	a1 := NewAction("slow", time.Millisecond * 100, slow, WithRetry(RetryPolicy{
+		MaxAttempts:    3,
+		InitialBackoff: time.Millisecond * 10,
+		Multiplier:     2,
+		MaxBackoff:     time.Millisecond * 50,
+	}))
+	if err := wf.AddEdge(s1, a1, s2); err != nil {
+		t.Fatal(err)
+	}
+	if err := wf.Run(); err == nil {
+		t.Fatal("expected the workflow to fail since every attempt times out before slow returns")
+	}
+	if maxConcurrent != 1 {
+		t.Fatalf("expected at most 1 attempt in flight at a time, saw %d", maxConcurrent)
+	}
+}
+
+func TestWorkFlowConditionalEdge (t *testing.T) {
+	wf := NewWorkFlow(time.Second * 5)
+	start := NewState("start")
+	onB := NewState("onB")
+	done := NewState("done", WithJoin(JoinAny))
+	choice := NewAction("choice", time.Second * 3, processTestFunc)
+	if err := wf.AddConditionalEdge(start, choice, func(ctx context.Context, output Value) string {
+		return "b"
+	}, map[string]State{
+		"a": NewState("onA"),
+		"b": onB,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := wf.AddEdge(onB, NewAction("afterB", time.Second * 3, processTestFunc), done); err != nil {
+		t.Fatal(err)
+	}
+	if err := wf.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if onB.Status() != StatusDone {
+		t.Fail()
+	}
+}
+
+func TestWorkFlowConditionalEdgeSkipReachesDownstreamMerge (t *testing.T) {
+	wf := NewWorkFlow(time.Second * 5)
+	start := NewState("start")
+	onA := NewState("onA")
+	onB := NewState("onB")
+	merge := NewState("merge")
+	choice := NewAction("choice", time.Second * 3, processTestFunc)
+	if err := wf.AddConditionalEdge(start, choice, func(ctx context.Context, output Value) string {
+		return "b"
+	}, map[string]State{
+		"a": onA,
+		"b": onB,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := wf.AddEdge(onA, NewAction("doWorkA", time.Second * 3, processTestFunc), merge); err != nil {
+		t.Fatal(err)
+	}
+	if err := wf.AddEdge(onB, NewAction("doWorkB", time.Second * 3, processTestFunc), merge); err != nil {
+		t.Fatal(err)
+	}
+	if err := wf.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if merge.Status() != StatusDone {
+		t.Fatal("expected merge to complete once the taken branch's work finished, not wait on the skipped branch")
+	}
+}
+
+func TestWorkFlowTypedDataFlow (t *testing.T) {
+	wf := NewWorkFlow(time.Second * 5)
+	s1 := NewState("start")
+	s2 := NewState("middle")
+	s3 := NewState("end")
+
+	produce := NewAction("produce", time.Second * 3, func(ctx context.Context, inputs ...Value) (Value, error) {
+		return NewValue(21), nil
+	}, WithOutputType(reflect.TypeOf(0)))
+
+	var doubled int
+	consume := NewAction("consume", time.Second * 3, func(ctx context.Context, inputs ...Value) (Value, error) {
+		doubled = inputs[0].Data.(int) * 2
+		return Value{}, nil
+	}, WithInputTypes(reflect.TypeOf(0)))
+
+	if err := wf.AddEdge(s1, produce, s2); err != nil {
+		t.Fatal(err)
+	}
+	if err := wf.AddEdge(s2, consume, s3); err != nil {
+		t.Fatal(err)
+	}
+	if err := wf.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if doubled != 42 {
+		t.Fatalf("expected consume to receive produce's output, got %d", doubled)
+	}
+}
+
+func TestWorkFlowAddEdgeRejectsMismatchedInputType (t *testing.T) {
+	wf := NewWorkFlow(time.Second * 5)
+	s1 := NewState("start")
+	s2 := NewState("middle")
+	s3 := NewState("end")
+
+	produce := NewAction("produce", time.Second * 3, processTestFunc, WithOutputType(reflect.TypeOf(0)))
+	consume := NewAction("consume", time.Second * 3, processTestFunc, WithInputTypes(reflect.TypeOf("")))
+
+	if err := wf.AddEdge(s1, produce, s2); err != nil {
+		t.Fatal(err)
+	}
+	if err := wf.AddEdge(s2, consume, s3); err == nil {
+		t.Fatal("expected AddEdge to reject a mismatched input type")
+	}
+}
+
+// fakeAction is a minimal Action implementation that is not a
+// *StandardAction, used to exercise AddConditionalEdge's type check.
+type fakeAction struct {
+	StandardAction
+}
+
+func TestAddConditionalEdgeRejectsNonStandardAction (t *testing.T) {
+	wf := NewWorkFlow(time.Second * 5)
+	start := NewState("start")
+	fake := &fakeAction{}
+	if err := wf.AddConditionalEdge(start, fake, func(ctx context.Context, output Value) string {
+		return "a"
+	}, map[string]State{"a": NewState("onA")}); err == nil {
+		t.Fatal("expected AddConditionalEdge to reject a non-*StandardAction")
+	}
+}
+
+func TestResumeSkipsCompletedActionsAndRerunsPending (t *testing.T) {
+	var a1Runs, a2Runs int32
+	makeA1 := func() ProcessingFunc {
+		return func(ctx context.Context, inputs ...Value) (Value, error) {
+			atomic.AddInt32(&a1Runs, 1)
+			return NewValue(1), nil
+		}
+	}
+	makeA2 := func() ProcessingFunc {
+		return func(ctx context.Context, inputs ...Value) (Value, error) {
+			atomic.AddInt32(&a2Runs, 1)
+			return NewValue(2), nil
+		}
+	}
+
+	// Build the original workflow and manually drive it partway, as if a1
+	// had completed and a2 was still pending when a crash interrupted the
+	// run.
+	wf := NewWorkFlow(time.Second * 5)
+	s1 := NewState("start")
+	s2 := NewState("middle")
+	s3 := NewState("end")
+	a1 := NewAction("a1", time.Second*3, makeA1())
+	a2 := NewAction("a2", time.Second*3, makeA2())
+	if err := wf.AddEdge(s1, a1, s2); err != nil {
+		t.Fatal(err)
+	}
+	if err := wf.AddEdge(s2, a2, s3); err != nil {
+		t.Fatal(err)
+	}
+	if err := a1.Process(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := wf.SnapshotJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a1Runs != 1 {
+		t.Fatalf("expected a1 to have run once before the snapshot, got %d", a1Runs)
+	}
+
+	// Resume as a fresh process would: rebuild the states and actions with
+	// new processingFuncs and restore status from the snapshot.
+	states := map[string]State{
+		"start":  NewState("start"),
+		"middle": NewState("middle"),
+		"end":    NewState("end"),
+	}
+	actions := map[string]Action{
+		"a1": NewAction("a1", time.Second*3, makeA1()),
+		"a2": NewAction("a2", time.Second*3, makeA2()),
+	}
+	resumed, err := Resume(time.Second*5, data, states, actions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := resumed.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	if a1Runs != 1 {
+		t.Fatalf("expected Resume to skip the already-completed a1, got %d total runs", a1Runs)
+	}
+	if a2Runs != 1 {
+		t.Fatalf("expected Resume to rerun the still-pending a2, got %d total runs", a2Runs)
+	}
+}
+
+func TestGroupDeduplicatesConcurrentCalls (t *testing.T) {
+	g := NewGroup()
+	var calls int32
+	fn := func(ctx context.Context) (interface{}, error) {
+		atomic.AddInt32(&calls, 1)
+		time.Sleep(time.Millisecond * 100)
+		return "result", nil
+	}
+
+	var wg sync.WaitGroup
+	results := make([]interface{}, 5)
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			val, err := g.Do(context.Background(), "shared-key", fn)
+			if err != nil {
+				t.Error(err)
+			}
+			results[i] = val
+		}(i)
+	}
+	wg.Wait()
+
+	if calls != 1 {
+		t.Fatalf("expected fn to run once, ran %d times", calls)
+	}
+	for _, r := range results {
+		if r != "result" {
+			t.Fatalf("expected every caller to see the shared result, got %v", r)
+		}
+	}
+}
+
+func TestGroupPromotesWaiterOnLeaderCancel (t *testing.T) {
+	g := NewGroup()
+	started := make(chan struct{})
+	var startedOnce sync.Once
+	release := make(chan struct{})
+	fn := func(ctx context.Context) (interface{}, error) {
+		startedOnce.Do(func() { close(started) })
+		select {
+		case <- ctx.Done():
+			return nil, ctx.Err()
+		case <- release:
+			return "result", nil
+		}
+	}
+
+	leaderCtx, cancelLeader := context.WithCancel(context.Background())
+	go g.Do(leaderCtx, "shared-key", fn)
+	<- started
+
+	waiterDone := make(chan struct{})
+	var waiterVal interface{}
+	var waiterErr error
+	go func() {
+		waiterVal, waiterErr = g.Do(context.Background(), "shared-key", fn)
+		close(waiterDone)
+	}()
+	for {
+		g.mu.Lock()
+		n := len(g.calls["shared-key"].waiters)
+		g.mu.Unlock()
+		if n > 0 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	cancelLeader()
+	time.Sleep(time.Millisecond * 200)
+	close(release)
+
+	select {
+	case <- waiterDone:
+	case <- time.After(time.Second):
+		t.Fatal("waiter never completed after leader was canceled")
+	}
+	if waiterErr != nil {
+		t.Fatalf("expected promoted waiter to succeed, got %v", waiterErr)
+	}
+	if waiterVal != "result" {
+		t.Fatalf("expected promoted waiter to get the real result, got %v", waiterVal)
+	}
+}
+
+func TestGroupDoesNotPromoteOnGenuineError (t *testing.T) {
+	g := NewGroup()
+	started := make(chan struct{})
+	var startedOnce sync.Once
+	gate := make(chan struct{})
+	var calls int32
+	fn := func(ctx context.Context) (interface{}, error) {
+		startedOnce.Do(func() { close(started) })
+		n := atomic.AddInt32(&calls, 1)
+		if n == 1 {
+			<- gate
+			return nil, errors.New("real business error")
+		}
+		return "ok-on-retry", nil
+	}
+
+	leaderDone := make(chan struct{})
+	var leaderVal interface{}
+	var leaderErr error
+	go func() {
+		leaderVal, leaderErr = g.Do(context.Background(), "shared-key", fn)
+		close(leaderDone)
+	}()
+	<- started
+
+	waiterDone := make(chan struct{})
+	var waiterVal interface{}
+	var waiterErr error
+	go func() {
+		waiterVal, waiterErr = g.Do(context.Background(), "shared-key", fn)
+		close(waiterDone)
+	}()
+	for {
+		g.mu.Lock()
+		n := len(g.calls["shared-key"].waiters)
+		g.mu.Unlock()
+		if n > 0 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	close(gate)
+
+	select {
+	case <- leaderDone:
+	case <- time.After(time.Second):
+		t.Fatal("leader never completed")
+	}
+	select {
+	case <- waiterDone:
+	case <- time.After(time.Second):
+		t.Fatal("waiter never completed")
+	}
+
+	if leaderErr == nil || leaderErr.Error() != "real business error" {
+		t.Fatalf("expected leader to see the real error, got val=%v err=%v", leaderVal, leaderErr)
+	}
+	if waiterErr == nil || waiterErr.Error() != "real business error" {
+		t.Fatalf("expected waiter to see the real error, not a promoted retry, got val=%v err=%v", waiterVal, waiterErr)
+	}
+}